@@ -1,68 +1,35 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
+
+	"github.com/PrimeGoose/coub-backup/naming"
 )
 
-const DownloadInterval = time.Millisecond * 100
+// safeName returns coub's collision-resistant, filesystem-safe name, used
+// everywhere a coub's title is interpolated into a path instead of the raw,
+// unsanitized coub.Title.
+func safeName(coub Coub) string {
+	return naming.SanitizeTitle(coub.Title, coub.Permalink)
+}
 
-// ReadCoub Accepts a Coub struct
-// It generates a directory for the coub, creates the info file for it
-// And finally downloads all data for it
+// ReadCoub fetches the non-recoub list for user and processes it with a default
+// Downloader. See Downloader.Run for the concurrent, rate-limited pipeline. The
+// run is cancelled on SIGINT (Ctrl-C), so it aborts cleanly instead of leaving
+// half-written parts strewn across every in-flight coub.
 func ReadCoub(rootdir string, user string) (err error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	// rootdir should be the path to the user directory
-	// From there we will create our sub directories
-
-	coubs, err := GetNonRecoubs(rootdir, user)
-	if err != nil {
-		return err
-	}
-	log.Println("Total Coubs to process: " + strconv.Itoa(len(coubs)))
-
-	var wg sync.WaitGroup
-	for i, coub := range coubs {
-		coub.Title = strings.TrimSpace(coub.Title)
-		log.Println("Processing Coub: " + coub.Title)
-		// Create the directory for the coub
-		outdir, err := CreateCoubDir(rootdir, coub)
-		if err != nil {
-			return err
-		}
-
-		// Create the info file for the coub
-		err = CreateCoubInfoFiles(outdir, coub)
-		if err != nil {
-			return err
-		}
-
-		// Download all data for the coub
-		wg.Add(1)
-		go func(coubID int) {
-			log.Println("Downloading Coub: " + coubs[coubID].Title)
-			err = DownloadCoubData(&wg, outdir, coubs[coubID])
-			if err != nil {
-				log.Println("Error downloading coub: "+coubs[coubID].Title, err)
-			}
-		}(i)
-		time.Sleep(time.Second * 1)
-
-		// every 5 coubs, wait for the goroutines to finish
-		if i%5 == 0 {
-			wg.Wait()
-		}
-	}
-	wg.Wait()
-	log.Println("All found coubs downloaded")
-	return nil
+	d := NewDownloader(DefaultCoubWorkers, DefaultAssetWorkers, DefaultQPS, DefaultBurst)
+	return d.Run(ctx, rootdir, user)
 }
 
 func GetNonRecoubs(dir string, user string) (coubs []Coub, err error) {
@@ -86,199 +53,85 @@ func GetNonRecoubs(dir string, user string) (coubs []Coub, err error) {
 	return coubs, nil
 }
 
-func CreateCoubInfoFiles(dir string, coub Coub) (err error) {
+// CreateCoubInfoFiles writes metadata.json and info.txt for coub through store,
+// so an archive can be backed by local disk or an S3-compatible bucket
+// interchangeably.
+func CreateCoubInfoFiles(ctx context.Context, store Storer, dir string, coub Coub) (err error) {
 	// First we dump the coub struct into a json file
-	outputFile, _ := json.MarshalIndent(coub, "", " ")
-	err = ioutil.WriteFile(dir+"/metadata.json", outputFile, 0644)
-	if err != nil {
-		return err
-	}
-
-	infoFile, err := os.Create(dir + "/info.txt")
-	if err != nil {
-		fmt.Println("Unable to open file: %s", err)
-	}
-
-	_, err = infoFile.WriteString("Title: " + coub.Title + "\n")
-	if err != nil {
-		return err
-	}
-	_, err = infoFile.WriteString("Created At: " + coub.CreatedAt.String() + "\n")
+	outputFile, err := json.MarshalIndent(coub, "", " ")
 	if err != nil {
 		return err
 	}
-
-	_, err = infoFile.WriteString("Duration: " + fmt.Sprintf("%.2f", coub.Duration) + "\n")
-	if err != nil {
+	if err = store.Put(ctx, dir+"/metadata.json", bytes.NewReader(outputFile), nil); err != nil {
 		return err
 	}
 
-	_, err = infoFile.WriteString("Views: " + strconv.Itoa(coub.ViewsCount) + "\n")
-	if err != nil {
-		return err
-	}
-
-	_, err = infoFile.WriteString("Recoubs: " + strconv.Itoa(coub.RecoubsCount) + "\n")
-	if err != nil {
-		return err
-	}
-
-	_, err = infoFile.WriteString("Source: " + fmt.Sprintf("%v", coub.ExternalDownload) + "\n")
-
-	_, err = infoFile.WriteString("Tags: ")
+	var info strings.Builder
+	info.WriteString("Title: " + coub.Title + "\n")
+	info.WriteString("Created At: " + coub.CreatedAt.String() + "\n")
+	info.WriteString("Duration: " + fmt.Sprintf("%.2f", coub.Duration) + "\n")
+	info.WriteString("Views: " + strconv.Itoa(coub.ViewsCount) + "\n")
+	info.WriteString("Recoubs: " + strconv.Itoa(coub.RecoubsCount) + "\n")
+	info.WriteString("Source: " + fmt.Sprintf("%v", coub.ExternalDownload) + "\n")
 
+	info.WriteString("Tags: ")
 	for i, tag := range coub.Tags {
 		if i == len(coub.Tags)-1 {
-			_, err = infoFile.WriteString(tag.Title + "\n")
+			info.WriteString(tag.Title + "\n")
 		} else {
-			_, err = infoFile.WriteString(tag.Title + ", ")
-		}
-		if err != nil {
-			return err
+			info.WriteString(tag.Title + ", ")
 		}
 	}
 
-	err = infoFile.Close()
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return store.Put(ctx, dir+"/info.txt", strings.NewReader(info.String()), nil)
 }
 
-func DownloadCoubData(PoolWG *sync.WaitGroup, rootdir string, coub Coub) (err error) {
-	defer PoolWG.Done()
-
-	var wg sync.WaitGroup
-
-	wg.Add(1)
-	go func() {
-		log.Print("Downloading Frames for Coub: " + coub.Title)
-		err = DownloadFirstFrameVersions(&wg, rootdir, coub)
-		if err != nil {
-			log.Println("Error Downloading First Frame Versions: " + err.Error())
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		log.Print("Downloading Images for Coub: " + coub.Title)
-		err = DownloadImageVersions(&wg, rootdir, coub)
-		if err != nil {
-			log.Println("Error Downloading Image Versions: " + err.Error())
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		log.Print("Downloading Media Files for Coub: " + coub.Title)
-		err = DownloadFileVersions(&wg, rootdir, coub)
-		if err != nil {
-			log.Println("Error Downloading File Versions: " + err.Error())
-		}
-	}()
-
-	wg.Wait()
-	log.Println("Finished Downloading Coub: " + coub.Title)
-	return nil
-}
-
-func DownloadFileVersions(wg *sync.WaitGroup, filepath string, coub Coub) (err error) {
-	defer wg.Done()
-
-	url := coub.FileVersions.HTML5.Video.Med.URL
-	err = DownloadFile(filepath+"/"+FileNameFromURL(url), url)
-	if err != nil {
-		log.Println("Error downloading Medium Quality HTML5 Video for: " + coub.Title + ": " + err.Error())
-	}
-	time.Sleep(DownloadInterval)
-
-	url = coub.FileVersions.HTML5.Video.High.URL
-	err = DownloadFile(filepath+"/"+FileNameFromURL(url), url)
-	if err != nil {
-		log.Println("Error downloading High Quality HTML5 Video for: " + coub.Title + ": " + err.Error())
-	}
-	time.Sleep(DownloadInterval)
-
-	url = coub.FileVersions.HTML5.Video.Higher.URL
-	err = DownloadFile(filepath+"/"+FileNameFromURL(url), url)
-	if err != nil {
-		log.Println("Error downloading Higher Quality HTML5 Video for: " + coub.Title + ": " + err.Error())
-	}
-	time.Sleep(DownloadInterval)
-
-	url = coub.FileVersions.HTML5.Audio.High.URL
-	err = DownloadFile(filepath+"/"+FileNameFromURL(url), url)
-	if err != nil {
-		log.Println("Error downloading Higher Quality HTML5 Audio for: " + coub.Title + ": " + err.Error())
-	}
-	time.Sleep(DownloadInterval)
-
-	url = coub.FileVersions.HTML5.Audio.Med.URL
-	err = DownloadFile(filepath+"/"+FileNameFromURL(url), url)
-	if err != nil {
-		log.Println("Error downloading Medium Quality HTML5 Audio for: " + coub.Title + ": " + err.Error())
+// FileVersionJobs builds the AssetJobs for a coub's video/audio/share assets.
+// It replaces the old serial, sleep-throttled DownloadFileVersions: pacing is now
+// the shared Downloader rate.Limiter's job, applied when each job runs.
+func FileVersionJobs(filepath string, coub Coub) []AssetJob {
+	jobs := []AssetJob{
+		fileVersionJob("Medium Quality HTML5 Video", filepath, coub.FileVersions.HTML5.Video.Med.URL),
+		fileVersionJob("High Quality HTML5 Video", filepath, coub.FileVersions.HTML5.Video.High.URL),
+		fileVersionJob("Higher Quality HTML5 Video", filepath, coub.FileVersions.HTML5.Video.Higher.URL),
+		fileVersionJob("Higher Quality HTML5 Audio", filepath, coub.FileVersions.HTML5.Audio.High.URL),
+		fileVersionJob("Medium Quality HTML5 Audio", filepath, coub.FileVersions.HTML5.Audio.Med.URL),
+		fileVersionJob("Default Share File", filepath, coub.FileVersions.Share.Default),
 	}
-	time.Sleep(DownloadInterval)
 
 	// We do not download mobile versions, because they are the same as the medium quality HTML5 versions
-	/*
-		url = coub.FileVersions.Mobile.Video
-		err = DownloadFile(filepath+"/"+FileNameFromURL(url), url)
-		if err != nil {
-			log.Println("Error downloading Mobile Video for" + coub.Title + ": " + err.Error())
-		}
-		time.Sleep(DownloadInterval)
 
-		url = coub.FileVersions.Mobile.Audio[0]
-		err = DownloadFile(filepath+"/"+FileNameFromURL(url), url)
-		if err != nil {
-			log.Println("Error downloading Mobile Audio for" + coub.Title + ": " + err.Error())
-		}
-		time.Sleep(DownloadInterval)
-	*/
+	jobs = append(jobs, AssetJob{
+		Label: "Renamed Default Share File",
+		Dest:  filepath + "/" + safeName(coub) + ".mp4",
+		URL:   coub.FileVersions.Share.Default,
+	})
 
-	url = coub.FileVersions.Share.Default
-	err = DownloadFile(filepath+"/"+FileNameFromURL(url), url)
-	if err != nil {
-		log.Println("Error downloading Default Share File for: " + coub.Title + ": " + err.Error())
-	}
-
-	url = coub.FileVersions.Share.Default
-	err = DownloadFile(filepath+"/"+coub.Title+".mp4", url)
-	if err != nil {
-		log.Println("Error downloading (renamed) Default Share File for: " + coub.Title + ": " + err.Error())
-	}
-
-	return nil
+	return jobs
 }
 
-func DownloadImageVersions(wg *sync.WaitGroup, filepath string, coub Coub) (err error) {
-	defer wg.Done()
+func fileVersionJob(label, filepath, url string) AssetJob {
+	return AssetJob{Label: label, Dest: filepath + "/" + FileNameFromURL(url), URL: url}
+}
 
+// ImageVersionJobs builds the AssetJobs for every rendered ImageVersions size.
+func ImageVersionJobs(filepath string, coub Coub) []AssetJob {
+	var jobs []AssetJob
 	template := coub.ImageVersions.Template
 	for _, version := range coub.ImageVersions.Versions {
 		url := strings.Replace(template, "%{version}", version, -1)
-		err = DownloadFile(filepath+"/"+FileNameFromURL(url), url)
-		if err != nil {
-			return err
-		}
-		time.Sleep(time.Second * 1)
+		jobs = append(jobs, AssetJob{Label: "Image Version " + version, Dest: filepath + "/" + FileNameFromURL(url), URL: url})
 	}
-	return nil
+	return jobs
 }
 
-func DownloadFirstFrameVersions(wg *sync.WaitGroup, filepath string, coub Coub) (err error) {
-	defer wg.Done()
-
+// FirstFrameVersionJobs builds the AssetJobs for every rendered FirstFrameVersions size.
+func FirstFrameVersionJobs(filepath string, coub Coub) []AssetJob {
+	var jobs []AssetJob
 	template := coub.FirstFrameVersions.Template
 	for _, version := range coub.FirstFrameVersions.Versions {
 		url := strings.Replace(template, "%{version}", version, -1)
-		err = DownloadFile(filepath+"/"+FileNameFromURL(url), url)
-		if err != nil {
-			return err
-		}
-		time.Sleep(time.Second * 1)
+		jobs = append(jobs, AssetJob{Label: "First Frame Version " + version, Dest: filepath + "/" + FileNameFromURL(url), URL: url})
 	}
-	return nil
+	return jobs
 }
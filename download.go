@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// DownloadFile fetches url to destPath, resuming from a partial `.part` file if one
+// exists and the server supports Range requests, and retrying transient failures
+// with exponential backoff. On success the `.part` file is atomically renamed to
+// destPath. A pre-existing, already-complete destPath is left untouched.
+func DownloadFile(destPath string, url string) (err error) {
+	if _, statErr := os.Stat(destPath); statErr == nil {
+		return nil
+	}
+
+	partPath := destPath + ".part"
+
+	return withRetry(DefaultRetryConfig, func() error {
+		return downloadOnce(partPath, destPath, url)
+	})
+}
+
+func downloadOnce(partPath, destPath, url string) error {
+	var offset int64
+	if fi, statErr := os.Stat(partPath); statErr == nil {
+		offset = fi.Size()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case isPermanentStatus(resp.StatusCode):
+		return &PermanentError{Err: fmt.Errorf("%s: %s", url, resp.Status)}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// Server has nothing more to give us for this range; assume the
+		// existing .part is already complete and let the caller re-verify.
+		return os.Rename(partPath, destPath)
+	case resp.StatusCode >= 500:
+		return &ServerError{StatusCode: resp.StatusCode, Err: fmt.Errorf("%s: %s", url, resp.Status)}
+	case offset > 0 && resp.StatusCode == http.StatusOK:
+		// Server ignored our Range header and is sending the file from the
+		// start; start the .part file over rather than appending its body
+		// onto our existing bytes.
+		offset = 0
+		if truncErr := os.Remove(partPath); truncErr != nil && !os.IsNotExist(truncErr) {
+			return truncErr
+		}
+	case offset > 0 && resp.StatusCode != http.StatusPartialContent:
+		// Anything else while resuming (4xx we don't already treat as
+		// permanent, an unfollowed redirect, ...) is not a body we should
+		// trust: writing it to disk and checksumming it would silently mark
+		// corrupt data as verified.
+		return fmt.Errorf("%s: unexpected status while resuming: %s", url, resp.Status)
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent:
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(partPath, destPath)
+}
+
+// sha256File computes the hex-encoded SHA-256 digest of the file at path.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
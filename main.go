@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// main parses the --incremental/--storage/--mux-quality/--mux-keep-parts flags
+// registered across this package and dispatches the remaining positional
+// arguments to a command: `status <rootdir> <user>`, `retry-failed <rootdir>
+// <user>`, or, with no leading command, a plain sync via ReadCoub.
+func main() {
+	flag.Parse()
+	args := flag.Args()
+
+	cmd := "sync"
+	switch {
+	case len(args) > 0 && args[0] == "status":
+		cmd, args = args[0], args[1:]
+	case len(args) > 0 && args[0] == "retry-failed":
+		cmd, args = args[0], args[1:]
+	}
+
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: coub-backup [status|retry-failed] <rootdir> <user>")
+		os.Exit(2)
+	}
+	rootdir, user := args[0], args[1]
+
+	var err error
+	switch cmd {
+	case "status":
+		err = StatusCommand(rootdir, user)
+	case "retry-failed":
+		err = RetryFailedCommand(rootdir, user)
+	default:
+		err = ReadCoub(rootdir, user)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// ManifestEntry records the verified state of a single downloaded asset.
+type ManifestEntry struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Manifest tracks per-asset checksums for a single coub, stored alongside
+// metadata.json so a second run can skip files it already verified. Verified
+// and Record are called concurrently from multiple asset workers, so Files is
+// guarded by mu rather than accessed directly.
+type Manifest struct {
+	Files map[string]ManifestEntry `json:"files"`
+
+	mu   sync.Mutex
+	path string
+}
+
+// newManifest builds an empty Manifest rooted at dir, ready to Record into and
+// Save. LoadManifest and downloadAssets's load-failure fallback both go through
+// this instead of constructing a bare literal, so path is never left unset.
+func newManifest(dir string) *Manifest {
+	return &Manifest{Files: map[string]ManifestEntry{}, path: dir + "/manifest.json"}
+}
+
+// LoadManifest reads manifest.json from dir, returning an empty Manifest if it
+// does not exist yet.
+func LoadManifest(dir string) (*Manifest, error) {
+	m := newManifest(dir)
+
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Verified reports whether destPath is already recorded in the manifest and its
+// SHA-256 still matches the file on disk.
+func (m *Manifest) Verified(destPath string) bool {
+	m.mu.Lock()
+	entry, ok := m.Files[destPath]
+	m.mu.Unlock()
+	if !ok {
+		return false
+	}
+	sum, err := sha256File(destPath)
+	if err != nil {
+		return false
+	}
+	return sum == entry.SHA256
+}
+
+// Record computes destPath's SHA-256 and upserts it into the manifest.
+func (m *Manifest) Record(destPath string) error {
+	sum, err := sha256File(destPath)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(destPath)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.Files[destPath] = ManifestEntry{SHA256: sum, Size: fi.Size()}
+	m.mu.Unlock()
+	return nil
+}
+
+// Save writes the manifest back to manifest.json in its directory. It writes to
+// a temp file and renames it into place so a crash mid-write never leaves a
+// truncated manifest.json that a later LoadManifest can't parse.
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	data, err := json.MarshalIndent(m, "", " ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+var (
+	muxQuality   = flag.String("mux-quality", "higher", "HTML5 video quality tier to mux (med, high, or higher)")
+	muxKeepParts = flag.Bool("mux-keep-parts", true, "keep the raw downloaded video/audio parts after muxing")
+)
+
+// ErrFFmpegUnavailable is returned by Muxer.Mux when ffmpeg isn't on PATH. It is
+// not a per-coub failure: callers should skip muxing and continue the rest of
+// the pipeline (upload, NFO, state) rather than treat the whole coub as failed.
+var ErrFFmpegUnavailable = errors.New("ffmpeg not found on PATH")
+
+// Muxer combines a coub's separately-downloaded, silent, looping video with its
+// audio track into a single playable MP4, mirroring what post-download tools like
+// ytsync do to hand users a canonical artifact instead of raw stream parts.
+type Muxer struct {
+	Quality   string
+	KeepParts bool
+}
+
+// NewMuxer builds a Muxer from the --mux-quality and --mux-keep-parts flags.
+func NewMuxer() *Muxer {
+	return &Muxer{Quality: *muxQuality, KeepParts: *muxKeepParts}
+}
+
+// Mux shells out to ffmpeg to loop coub's video for coub.Duration and combine it
+// with its audio, writing `<title>_looped.mp4` into dir. Downloader.processCoub
+// invokes it after downloadAssets returns for a coub, once every asset is on disk.
+func (m *Muxer) Mux(dir string, coub Coub) error {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return ErrFFmpegUnavailable
+	}
+
+	videoURL, err := m.videoURL(coub)
+	if err != nil {
+		return err
+	}
+	audioURL := coub.FileVersions.HTML5.Audio.High.URL
+
+	videoPath := dir + "/" + FileNameFromURL(videoURL)
+	audioPath := dir + "/" + FileNameFromURL(audioURL)
+	outPath := dir + "/" + safeName(coub) + "_looped.mp4"
+
+	if _, err := os.Stat(outPath); err == nil {
+		return nil
+	}
+
+	filter := fmt.Sprintf("[0:v]trim=duration=%.2f[v]", coub.Duration)
+	cmd := exec.Command(ffmpeg,
+		"-y",
+		"-stream_loop", "-1", "-i", videoPath,
+		"-i", audioPath,
+		"-filter_complex", filter,
+		"-map", "[v]", "-map", "1:a",
+		"-shortest",
+		outPath,
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg mux failed for %s: %w", coub.Title, err)
+	}
+
+	if !m.KeepParts {
+		os.Remove(videoPath)
+		os.Remove(audioPath)
+	}
+
+	return nil
+}
+
+func (m *Muxer) videoURL(coub Coub) (string, error) {
+	switch m.Quality {
+	case "med":
+		return coub.FileVersions.HTML5.Video.Med.URL, nil
+	case "high":
+		return coub.FileVersions.HTML5.Video.High.URL, nil
+	case "higher":
+		return coub.FileVersions.HTML5.Video.Higher.URL, nil
+	default:
+		return "", fmt.Errorf("unknown mux quality tier: %q", m.Quality)
+	}
+}
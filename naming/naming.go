@@ -0,0 +1,32 @@
+// Package naming provides collision-resistant, filesystem-safe names for coubs,
+// modeled on the claim-name sanitization used by YouTube-ingest tools like ytsync.
+package naming
+
+import (
+	"regexp"
+	"strings"
+)
+
+// maxTitleLength caps the sanitized portion of the name before the id suffix is
+// appended, keeping the final name well under typical filesystem limits even
+// after quality/version suffixes and extensions are added on top.
+const maxTitleLength = 40
+
+var unsafeRun = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// SanitizeTitle turns title into a lowercase, hyphenated, length-capped name and
+// appends id so that coubs whose titles collide after sanitization (including
+// empty, emoji-only, or purely-symbolic titles) still get distinct filenames.
+func SanitizeTitle(title, id string) string {
+	slug := unsafeRun.ReplaceAllString(strings.ToLower(title), "-")
+	slug = strings.Trim(slug, "-")
+
+	if len(slug) > maxTitleLength {
+		slug = strings.Trim(slug[:maxTitleLength], "-")
+	}
+
+	if slug == "" {
+		return id
+	}
+	return slug + "-" + id
+}
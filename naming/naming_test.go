@@ -0,0 +1,48 @@
+package naming
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeTitle(t *testing.T) {
+	cases := []struct {
+		name  string
+		title string
+		id    string
+		want  string
+	}{
+		{"simple", "My Cool Coub", "123", "my-cool-coub-123"},
+		{"path separators", "a/b\\c:d", "123", "a-b-c-d-123"},
+		{"control chars", "hello\x00world\n", "123", "hello-world-123"},
+		{"empty title", "", "123", "123"},
+		{"emoji only", "🎉🎉🎉", "123", "123"},
+		{"windows reserved name", "CON", "123", "con-123"},
+		{"leading and trailing separators", "---weird---", "123", "weird-123"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := SanitizeTitle(c.title, c.id)
+			if got != c.want {
+				t.Errorf("SanitizeTitle(%q, %q) = %q, want %q", c.title, c.id, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeTitleCapsLength(t *testing.T) {
+	long := strings.Repeat("a", 500)
+	got := SanitizeTitle(long, "123")
+	if len(got) > maxTitleLength+1+len("123") {
+		t.Errorf("SanitizeTitle did not cap length, got %d chars: %q", len(got), got)
+	}
+}
+
+func TestSanitizeTitleUniqueSuffix(t *testing.T) {
+	a := SanitizeTitle("Same Title", "1")
+	b := SanitizeTitle("Same Title", "2")
+	if a == b {
+		t.Errorf("expected distinct names for distinct ids, got %q for both", a)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type nfoTag struct {
+	XMLName xml.Name `xml:"tag"`
+	Value   string   `xml:",chardata"`
+}
+
+type nfoUniqueID struct {
+	XMLName xml.Name `xml:"uniqueid"`
+	Type    string   `xml:"type,attr"`
+	Value   string   `xml:",chardata"`
+}
+
+// nfoMusicVideo follows Kodi's musicvideo NFO schema, which fits a coub better
+// than the movie schema: a short looping visual set to a soundtrack.
+type nfoMusicVideo struct {
+	XMLName   xml.Name    `xml:"musicvideo"`
+	Title     string      `xml:"title"`
+	Plot      string      `xml:"plot"`
+	DateAdded string      `xml:"dateadded"`
+	Runtime   string      `xml:"runtime"`
+	Studio    string      `xml:"studio"`
+	Tags      []nfoTag    `xml:"tag"`
+	UniqueID  nfoUniqueID `xml:"uniqueid"`
+}
+
+// WriteNFO writes a Jellyfin/Kodi-compatible `<title>.nfo` sidecar for coub,
+// plus a `-poster.jpg` copy of its largest ImageVersions frame and a `-thumb.jpg`
+// copy of its first frame, so a coub-backup directory becomes drop-in
+// importable by a Jellyfin or Kodi library instead of an opaque dump.
+func WriteNFO(ctx context.Context, store Storer, dir string, coub Coub) error {
+	nfo := nfoMusicVideo{
+		Title:     coub.Title,
+		Plot:      coub.Description,
+		DateAdded: coub.CreatedAt.Format("2006-01-02 15:04:05"),
+		Runtime:   strconv.Itoa(int(coub.Duration / 60)),
+		Studio:    "Coub",
+		UniqueID:  nfoUniqueID{Type: "coub", Value: strconv.Itoa(coub.ID)},
+	}
+	for _, tag := range coub.Tags {
+		nfo.Tags = append(nfo.Tags, nfoTag{Value: tag.Title})
+	}
+
+	out, err := xml.MarshalIndent(nfo, "", " ")
+	if err != nil {
+		return err
+	}
+	out = append([]byte(xml.Header), out...)
+
+	if err := store.Put(ctx, dir+"/"+safeName(coub)+".nfo", bytes.NewReader(out), nil); err != nil {
+		return err
+	}
+
+	if err := copyPoster(ctx, store, dir, coub); err != nil {
+		return err
+	}
+	return copyThumb(ctx, store, dir, coub)
+}
+
+// copyPoster copies the largest rendered ImageVersions frame to `<title>-poster.jpg`.
+func copyPoster(ctx context.Context, store Storer, dir string, coub Coub) error {
+	versions := coub.ImageVersions.Versions
+	if len(versions) == 0 {
+		return nil
+	}
+	url := strings.Replace(coub.ImageVersions.Template, "%{version}", versions[len(versions)-1], -1)
+	return copySidecar(ctx, store, dir, url, safeName(coub)+"-poster.jpg")
+}
+
+// copyThumb copies the first rendered FirstFrameVersions frame to `<title>-thumb.jpg`.
+func copyThumb(ctx context.Context, store Storer, dir string, coub Coub) error {
+	versions := coub.FirstFrameVersions.Versions
+	if len(versions) == 0 {
+		return nil
+	}
+	url := strings.Replace(coub.FirstFrameVersions.Template, "%{version}", versions[0], -1)
+	return copySidecar(ctx, store, dir, url, safeName(coub)+"-thumb.jpg")
+}
+
+func copySidecar(ctx context.Context, store Storer, dir, sourceURL, name string) error {
+	src := dir + "/" + FileNameFromURL(sourceURL)
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return store.Put(ctx, dir+"/"+name, f, nil)
+}
+
+// WritePlaylist writes a per-user coubs.m3u8 listing each coub's muxed MP4 in
+// chronological order, so a whole archive drops straight into a media server
+// playlist. coubs must already be filtered down to ones that were actually
+// muxed (see Downloader.RunCoubs) — entries for coubs without a `_looped.mp4`
+// on disk would be dead links.
+func WritePlaylist(ctx context.Context, store Storer, rootdir string, coubs []Coub) error {
+	sorted := make([]Coub, len(coubs))
+	copy(sorted, coubs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].CreatedAt.Before(sorted[j].CreatedAt) })
+
+	var playlist bytes.Buffer
+	playlist.WriteString("#EXTM3U\n")
+	for _, coub := range sorted {
+		outdir, err := CreateCoubDir(rootdir, coub)
+		if err != nil {
+			return err
+		}
+		playlist.WriteString("#EXTINF:-1," + coub.Title + "\n")
+		playlist.WriteString(outdir + "/" + safeName(coub) + "_looped.mp4\n")
+	}
+
+	return store.Put(ctx, rootdir+"/coubs.m3u8", &playlist, nil)
+}
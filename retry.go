@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryConfig controls the exponential-backoff retry loop used by DownloadFile.
+type RetryConfig struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      time.Duration
+}
+
+// DefaultRetryConfig is used whenever a download call site doesn't supply its own.
+var DefaultRetryConfig = RetryConfig{
+	MaxAttempts: 5,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Second * 30,
+	Jitter:      time.Millisecond * 250,
+}
+
+// isPermanentStatus reports HTTP status codes that will never succeed on retry.
+func isPermanentStatus(statusCode int) bool {
+	return statusCode == http.StatusNotFound || statusCode == http.StatusForbidden
+}
+
+// isRetryableErr reports whether err looks transient (timeout, connection reset,
+// or 5xx) rather than permanent. Anything else — including non-network errors
+// like a failed os.OpenFile/os.Rename — is treated as permanent so we don't burn
+// 5 attempts on an error a retry can never fix.
+func isRetryableErr(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, http.ErrHandlerTimeout) {
+		return true
+	}
+
+	var svrErr *ServerError
+	if errors.As(err, &svrErr) {
+		return true
+	}
+
+	// net.OpError covers connection-reset/refused and other dial/read/write
+	// failures below the HTTP layer.
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// withRetry runs fn, retrying with exponential backoff and jitter until it succeeds,
+// it returns a *PermanentError or any other error isRetryableErr rejects, or
+// cfg.MaxAttempts is exhausted.
+func withRetry(cfg RetryConfig, fn func() error) (err error) {
+	delay := cfg.BaseDelay
+	for attempt := 1; attempt <= cfg.MaxAttempts; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+
+		var perm *PermanentError
+		if errors.As(err, &perm) {
+			return err
+		}
+
+		if attempt == cfg.MaxAttempts || !isRetryableErr(err) {
+			return err
+		}
+
+		sleep := delay
+		if cfg.Jitter > 0 {
+			sleep += time.Duration(rand.Int63n(int64(cfg.Jitter)))
+		}
+		time.Sleep(sleep)
+
+		delay *= 2
+		if delay > cfg.MaxDelay {
+			delay = cfg.MaxDelay
+		}
+	}
+	return err
+}
+
+// PermanentError wraps an error that a retry loop should not retry (e.g. HTTP 404/403).
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// ServerError wraps a 5xx HTTP response, which withRetry treats as retryable.
+type ServerError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *ServerError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeTimeoutErr satisfies net.Error, which (despite Temporary being
+// deprecated on the interface's real-world implementations) still requires it
+// to be implemented for errors.As to match.
+type fakeTimeoutErr struct{}
+
+func (fakeTimeoutErr) Error() string   { return "fake timeout" }
+func (fakeTimeoutErr) Timeout() bool   { return true }
+func (fakeTimeoutErr) Temporary() bool { return true }
+
+func TestIsRetryableErr(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"timeout net.Error", fakeTimeoutErr{}, true},
+		{"server error", &ServerError{StatusCode: 503, Err: errors.New("service unavailable")}, true},
+		{"connection reset", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, true},
+		{"permanent error", &PermanentError{Err: errors.New("404 not found")}, false},
+		{"plain error", errors.New("boom"), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isRetryableErr(c.err); got != c.want {
+				t.Errorf("isRetryableErr(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestWithRetrySucceedsAfterTransientFailures(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Jitter: 0}
+
+	attempts := 0
+	err := withRetry(cfg, func() error {
+		attempts++
+		if attempts < 3 {
+			return &ServerError{StatusCode: 503, Err: errors.New("service unavailable")}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("withRetry returned %v, want nil", err)
+	}
+	if attempts != 3 {
+		t.Errorf("fn called %d times, want 3", attempts)
+	}
+}
+
+func TestWithRetryStopsOnPermanentError(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Jitter: 0}
+
+	attempts := 0
+	permErr := &PermanentError{Err: errors.New("404 not found")}
+	err := withRetry(cfg, func() error {
+		attempts++
+		return permErr
+	})
+
+	if !errors.Is(err, permErr) {
+		t.Errorf("withRetry returned %v, want %v", err, permErr)
+	}
+	if attempts != 1 {
+		t.Errorf("fn called %d times, want 1 (permanent errors should not retry)", attempts)
+	}
+}
+
+func TestWithRetryExhaustsMaxAttempts(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Jitter: 0}
+
+	attempts := 0
+	retryableErr := &ServerError{StatusCode: 503, Err: errors.New("service unavailable")}
+	err := withRetry(cfg, func() error {
+		attempts++
+		return retryableErr
+	})
+
+	if !errors.Is(err, retryableErr) {
+		t.Errorf("withRetry returned %v, want %v", err, retryableErr)
+	}
+	if attempts != cfg.MaxAttempts {
+		t.Errorf("fn called %d times, want %d", attempts, cfg.MaxAttempts)
+	}
+}
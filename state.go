@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var incremental = flag.Bool("incremental", false, "only process coubs new or changed since the last sync, tracked in a local SQLite state DB")
+
+const stateSchema = `
+CREATE TABLE IF NOT EXISTS coubs (
+	id             INTEGER PRIMARY KEY,
+	permalink      TEXT NOT NULL,
+	updated_at     TEXT NOT NULL,
+	status         TEXT NOT NULL,
+	last_synced_at TEXT NOT NULL
+);
+`
+
+// StateDB is the local SQLite database an --incremental sync consults instead of
+// re-processing every entry in `<user>.json` on every run.
+type StateDB struct {
+	db *sql.DB
+}
+
+// OpenStateDB opens (creating if necessary) the state database at path and
+// applies its schema.
+func OpenStateDB(path string) (*StateDB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(stateSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &StateDB{db: db}, nil
+}
+
+func (s *StateDB) Close() error {
+	return s.db.Close()
+}
+
+// PendingCoubs filters coubs down to those the state DB has never seen, or whose
+// UpdatedAt is newer than the last synced value.
+func (s *StateDB) PendingCoubs(coubs []Coub) ([]Coub, error) {
+	var pending []Coub
+	for _, coub := range coubs {
+		var updatedAt string
+		err := s.db.QueryRow(`SELECT updated_at FROM coubs WHERE id = ?`, coub.ID).Scan(&updatedAt)
+		switch {
+		case err == sql.ErrNoRows:
+			pending = append(pending, coub)
+		case err != nil:
+			return nil, err
+		default:
+			seen, parseErr := time.Parse(time.RFC3339, updatedAt)
+			if parseErr != nil || coub.UpdatedAt.After(seen) {
+				pending = append(pending, coub)
+			}
+		}
+	}
+	return pending, nil
+}
+
+// MarkComplete upserts coub as successfully synced.
+func (s *StateDB) MarkComplete(coub Coub) error {
+	return s.upsert(coub, "complete")
+}
+
+// MarkFailed upserts coub as failed, so it shows up under `retry-failed`.
+func (s *StateDB) MarkFailed(coub Coub) error {
+	return s.upsert(coub, "failed")
+}
+
+func (s *StateDB) upsert(coub Coub, status string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO coubs (id, permalink, updated_at, status, last_synced_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			permalink = excluded.permalink,
+			updated_at = excluded.updated_at,
+			status = excluded.status,
+			last_synced_at = excluded.last_synced_at
+	`, coub.ID, coub.Permalink, coub.UpdatedAt.Format(time.RFC3339), status, time.Now().Format(time.RFC3339))
+	return err
+}
+
+// Counts returns the number of coubs recorded as failed and complete. Pending
+// (never-synced or changed-since-last-sync) coubs aren't tracked as a status
+// row in the coubs table — callers wanting that count should diff the full
+// `<user>.json` list with PendingCoubs, as StatusCommand does.
+func (s *StateDB) Counts() (failed, complete int, err error) {
+	rows, err := s.db.Query(`SELECT status, COUNT(*) FROM coubs GROUP BY status`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return 0, 0, err
+		}
+		switch status {
+		case "failed":
+			failed = count
+		case "complete":
+			complete = count
+		}
+	}
+	return failed, complete, rows.Err()
+}
+
+// FailedIDs returns the coub IDs currently recorded as failed.
+func (s *StateDB) FailedIDs() (map[int]bool, error) {
+	rows, err := s.db.Query(`SELECT id FROM coubs WHERE status = 'failed'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// stateDBPath is where a rootdir's incremental sync state lives.
+func stateDBPath(rootdir string) string {
+	return rootdir + "/.coub-backup.db"
+}
+
+// StatusCommand implements `coub-backup status <rootdir> <user>`, printing pending/failed/
+// complete counts from the local state DB.
+func StatusCommand(rootdir, user string) error {
+	state, err := OpenStateDB(stateDBPath(rootdir))
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	coubs, err := GetNonRecoubs(rootdir, user)
+	if err != nil {
+		return err
+	}
+	pending, err := state.PendingCoubs(coubs)
+	if err != nil {
+		return err
+	}
+	failed, complete, err := state.Counts()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("pending: %d\nfailed: %d\ncomplete: %d\n", len(pending), failed, complete)
+	return nil
+}
+
+// RetryFailedCommand implements `coub-backup retry-failed <rootdir> <user>`, re-running
+// only the coubs the state DB has recorded as failed. The run is cancelled on
+// SIGINT (Ctrl-C) the same way ReadCoub's is.
+func RetryFailedCommand(rootdir, user string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	state, err := OpenStateDB(stateDBPath(rootdir))
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	failedIDs, err := state.FailedIDs()
+	if err != nil {
+		return err
+	}
+
+	coubs, err := GetNonRecoubs(rootdir, user)
+	if err != nil {
+		return err
+	}
+	var retry []Coub
+	for _, coub := range coubs {
+		if failedIDs[coub.ID] {
+			retry = append(retry, coub)
+		}
+	}
+
+	return NewDownloader(DefaultCoubWorkers, DefaultAssetWorkers, DefaultQPS, DefaultBurst).RunCoubs(ctx, retry, rootdir, state)
+}
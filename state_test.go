@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStateDB(t *testing.T) *StateDB {
+	t.Helper()
+	db, err := OpenStateDB(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("OpenStateDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestPendingCoubsNeverSeen(t *testing.T) {
+	state := newTestStateDB(t)
+
+	coub := Coub{ID: 1, Permalink: "a", UpdatedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	pending, err := state.PendingCoubs([]Coub{coub})
+	if err != nil {
+		t.Fatalf("PendingCoubs: %v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("got %d pending, want 1 for a never-synced coub", len(pending))
+	}
+}
+
+func TestPendingCoubsSkipsUnchanged(t *testing.T) {
+	state := newTestStateDB(t)
+
+	coub := Coub{ID: 1, Permalink: "a", UpdatedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	if err := state.MarkComplete(coub); err != nil {
+		t.Fatalf("MarkComplete: %v", err)
+	}
+
+	pending, err := state.PendingCoubs([]Coub{coub})
+	if err != nil {
+		t.Fatalf("PendingCoubs: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("got %d pending, want 0 for an unchanged, already-synced coub", len(pending))
+	}
+}
+
+func TestPendingCoubsIncludesUpdated(t *testing.T) {
+	state := newTestStateDB(t)
+
+	original := Coub{ID: 1, Permalink: "a", UpdatedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	if err := state.MarkComplete(original); err != nil {
+		t.Fatalf("MarkComplete: %v", err)
+	}
+
+	updated := original
+	updated.UpdatedAt = original.UpdatedAt.Add(time.Hour)
+
+	pending, err := state.PendingCoubs([]Coub{updated})
+	if err != nil {
+		t.Fatalf("PendingCoubs: %v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("got %d pending, want 1 for a coub updated since the last sync", len(pending))
+	}
+}
+
+func TestCountsAndFailedIDs(t *testing.T) {
+	state := newTestStateDB(t)
+
+	when := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ok := Coub{ID: 1, Permalink: "a", UpdatedAt: when}
+	bad := Coub{ID: 2, Permalink: "b", UpdatedAt: when}
+	if err := state.MarkComplete(ok); err != nil {
+		t.Fatalf("MarkComplete: %v", err)
+	}
+	if err := state.MarkFailed(bad); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+
+	failed, complete, err := state.Counts()
+	if err != nil {
+		t.Fatalf("Counts: %v", err)
+	}
+	if failed != 1 || complete != 1 {
+		t.Fatalf("Counts() = (%d, %d), want (1, 1)", failed, complete)
+	}
+
+	failedIDs, err := state.FailedIDs()
+	if err != nil {
+		t.Fatalf("FailedIDs: %v", err)
+	}
+	if !failedIDs[bad.ID] || failedIDs[ok.ID] {
+		t.Fatalf("FailedIDs() = %v, want only %d marked failed", failedIDs, bad.ID)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var storageURI = flag.String("storage", "fs://.", "where to write coub archives: fs://<path> or s3://<bucket>/<prefix>")
+
+// StorerInfo describes an existing object in a Storer, mirroring the subset of
+// os.FileInfo that both local disk and S3 can report cheaply.
+type StorerInfo struct {
+	Size int64
+}
+
+// Storer abstracts the destination an archive's metadata.json, info.txt, NFO
+// sidecars, and downloaded assets are published to, so an archive can be backed
+// by local disk or an S3-compatible bucket. Asset downloads themselves are not
+// streamed through Put: DownloadFile's resumable Range/backoff logic needs a
+// local `.part` file to resume from, so assets always land on local disk first
+// and, for a non-local Storer, UploadCoubDir mirrors the finished coub directory
+// afterward. --storage=s3://... is therefore download-locally-then-mirror, not
+// direct-to-object-storage. A LocalStorer is never mirrored into: it writes
+// metadata.json, info.txt and the NFO sidecars to filepath.Join(Root, key),
+// while downloaded assets and manifest.json stay in the coub directory itself,
+// so the two sets of files share a directory only when that join leaves the
+// coub directory's path unchanged (Root "." and a relative rootdir).
+type Storer interface {
+	Put(ctx context.Context, key string, r io.Reader, meta map[string]string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	Stat(ctx context.Context, key string) (StorerInfo, error)
+}
+
+// NewStorerFromURI builds a Storer from a --storage URI: fs://<path> for local
+// disk or s3://<bucket>/<prefix> for an S3-compatible bucket.
+func NewStorerFromURI(uri string) (Storer, error) {
+	switch {
+	case strings.HasPrefix(uri, "fs://"):
+		return &LocalStorer{Root: strings.TrimPrefix(uri, "fs://")}, nil
+	case strings.HasPrefix(uri, "s3://"):
+		rest := strings.TrimPrefix(uri, "s3://")
+		parts := strings.SplitN(rest, "/", 2)
+		bucket := parts[0]
+		prefix := ""
+		if len(parts) == 2 {
+			prefix = parts[1]
+		}
+		cfg, err := awsconfig.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("loading AWS config: %w", err)
+		}
+		client := s3.NewFromConfig(cfg)
+		return &S3Storer{
+			Client:   client,
+			Uploader: manager.NewUploader(client),
+			Bucket:   bucket,
+			Prefix:   prefix,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unrecognized storage URI: %q (expected fs:// or s3://)", uri)
+	}
+}
+
+// LocalStorer implements Storer against the local filesystem, creating parent
+// directories as needed.
+type LocalStorer struct {
+	Root string
+}
+
+func (s *LocalStorer) path(key string) string {
+	return filepath.Join(s.Root, key)
+}
+
+func (s *LocalStorer) Put(ctx context.Context, key string, r io.Reader, meta map[string]string) error {
+	dest := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (s *LocalStorer) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LocalStorer) Stat(ctx context.Context, key string) (StorerInfo, error) {
+	fi, err := os.Stat(s.path(key))
+	if err != nil {
+		return StorerInfo{}, err
+	}
+	return StorerInfo{Size: fi.Size()}, nil
+}
+
+// S3Storer implements Storer against an S3-compatible bucket, using
+// manager.Uploader so large video files are sent as multipart uploads.
+type S3Storer struct {
+	Client   *s3.Client
+	Uploader *manager.Uploader
+	Bucket   string
+	Prefix   string
+}
+
+func (s *S3Storer) key(key string) string {
+	if s.Prefix == "" {
+		return key
+	}
+	return strings.TrimSuffix(s.Prefix, "/") + "/" + key
+}
+
+func (s *S3Storer) Put(ctx context.Context, key string, r io.Reader, meta map[string]string) error {
+	metadata := make(map[string]string, len(meta))
+	for k, v := range meta {
+		metadata[k] = v
+	}
+	_, err := s.Uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:   aws.String(s.Bucket),
+		Key:      aws.String(s.key(key)),
+		Body:     r,
+		Metadata: metadata,
+	})
+	return err
+}
+
+func (s *S3Storer) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *S3Storer) Stat(ctx context.Context, key string) (StorerInfo, error) {
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		return StorerInfo{}, err
+	}
+	return StorerInfo{Size: aws.ToInt64(out.ContentLength)}, nil
+}
+
+// UploadCoubDir publishes every file already downloaded into localDir to store
+// under key. It is a no-op for LocalStorer, since DownloadFile already wrote
+// directly into the local tree; it matters for remote backends like S3Storer,
+// which only see the finished files once this runs.
+func UploadCoubDir(ctx context.Context, store Storer, localDir, key string) error {
+	if _, ok := store.(*LocalStorer); ok {
+		return nil
+	}
+	return filepath.Walk(localDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return store.Put(ctx, key+"/"+rel, f, nil)
+	})
+}
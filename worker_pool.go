@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultCoubWorkers is how many coubs are processed concurrently.
+	DefaultCoubWorkers = 4
+	// DefaultAssetWorkers bounds how many assets download at once across all
+	// in-flight coubs, since every coub worker shares the same asset-worker pool.
+	DefaultAssetWorkers = 8
+	// DefaultQPS and DefaultBurst configure the shared rate.Limiter applied to every
+	// HTTP call the Downloader makes, regardless of how many coubs are in flight.
+	DefaultQPS   = 5.0
+	DefaultBurst = 5
+)
+
+// AssetJob is a single rate-limited, manifest-verified asset download.
+type AssetJob struct {
+	Label string
+	Dest  string
+	URL   string
+}
+
+func (j AssetJob) run(ctx context.Context, limiter *rate.Limiter, manifest *Manifest) error {
+	if manifest.Verified(j.Dest) {
+		return nil
+	}
+	if err := limiter.Wait(ctx); err != nil {
+		return err
+	}
+	if err := DownloadFile(j.Dest, j.URL); err != nil {
+		return &jobError{label: j.Label, err: err}
+	}
+	return manifest.Record(j.Dest)
+}
+
+type jobError struct {
+	label string
+	err   error
+}
+
+func (e *jobError) Error() string { return e.label + ": " + e.err.Error() }
+func (e *jobError) Unwrap() error { return e.err }
+
+// ProgressEvent reports a single coub's outcome as it moves through the pipeline.
+// A future UI can subscribe via Downloader.Progress instead of scraping log output.
+type ProgressEvent struct {
+	CoubID int
+	Title  string
+	Stage  string
+	Err    error
+}
+
+// Downloader runs the coub backup pipeline across a bounded pool of coub workers,
+// each of which submits its coub's asset jobs into a single shared, rate-limited
+// pool of AssetWorkers goroutines rather than spinning up its own — so actual
+// asset concurrency is AssetWorkers, not CoubWorkers*AssetWorkers. It replaces
+// the old wg.Add/go func/sleep pattern in ReadCoub.
+type Downloader struct {
+	CoubWorkers  int
+	AssetWorkers int
+	Limiter      *rate.Limiter
+	Muxer        *Muxer
+	Store        Storer
+	Progress     chan<- ProgressEvent
+
+	assetJobs chan assetTask
+}
+
+// assetTask is one AssetJob submitted to the shared asset-worker pool, along
+// with the manifest and context it belongs to and where to send its result.
+type assetTask struct {
+	ctx      context.Context
+	job      AssetJob
+	manifest *Manifest
+	result   chan<- error
+}
+
+// NewDownloader builds a Downloader with the given worker counts and a token-bucket
+// limiter allowing qps requests per second, up to burst at once. The storage
+// backend is resolved from the --storage flag.
+func NewDownloader(coubWorkers, assetWorkers int, qps float64, burst int) *Downloader {
+	store, err := NewStorerFromURI(*storageURI)
+	if err != nil {
+		log.Println("Error resolving --storage, defaulting to local disk: " + err.Error())
+		store = &LocalStorer{Root: "."}
+	}
+
+	d := &Downloader{
+		CoubWorkers:  coubWorkers,
+		AssetWorkers: assetWorkers,
+		Limiter:      rate.NewLimiter(rate.Limit(qps), burst),
+		Muxer:        NewMuxer(),
+		Store:        store,
+	}
+	d.startAssetWorkers()
+	return d
+}
+
+// startAssetWorkers launches d.AssetWorkers goroutines draining a single
+// shared assetJobs queue, so every coub worker's asset downloads compete for
+// the same bounded pool instead of each getting its own.
+func (d *Downloader) startAssetWorkers() {
+	d.assetJobs = make(chan assetTask)
+	for i := 0; i < d.AssetWorkers; i++ {
+		go func() {
+			for t := range d.assetJobs {
+				t.result <- t.job.run(t.ctx, d.Limiter, t.manifest)
+			}
+		}()
+	}
+}
+
+// Run fetches the coub list for user and fans it out across d.CoubWorkers
+// workers. When --incremental is set, the list is diffed against a local
+// SQLite state DB so only new or changed coubs are processed. It returns
+// ctx.Err() if ctx is cancelled (e.g. Ctrl-C) before every coub has been
+// processed.
+func (d *Downloader) Run(ctx context.Context, rootdir, user string) error {
+	var state *StateDB
+	if *incremental {
+		var err error
+		state, err = OpenStateDB(stateDBPath(rootdir))
+		if err != nil {
+			return err
+		}
+		defer state.Close()
+	}
+
+	coubs, err := GetNonRecoubs(rootdir, user)
+	if err != nil {
+		return err
+	}
+	if state != nil {
+		if coubs, err = state.PendingCoubs(coubs); err != nil {
+			return err
+		}
+	}
+
+	return d.RunCoubs(ctx, coubs, rootdir, state)
+}
+
+// RunCoubs fans coubs out across d.CoubWorkers workers, feeding each one through
+// the download/mux/store/nfo pipeline. If state is non-nil, each coub's outcome
+// is recorded so a later `status` or `retry-failed` run can consult it.
+func (d *Downloader) RunCoubs(ctx context.Context, coubs []Coub, rootdir string, state *StateDB) error {
+	log.Println("Total Coubs to process: " + strconv.Itoa(len(coubs)))
+
+	jobs := make(chan Coub)
+	muxedCh := make(chan Coub, len(coubs))
+	var wg sync.WaitGroup
+	for i := 0; i < d.CoubWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for coub := range jobs {
+				if d.processCoub(ctx, rootdir, coub, state) {
+					muxedCh <- coub
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, coub := range coubs {
+		coub.Title = strings.TrimSpace(coub.Title)
+		select {
+		case jobs <- coub:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(muxedCh)
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var muxed []Coub
+	for coub := range muxedCh {
+		muxed = append(muxed, coub)
+	}
+
+	if err := WritePlaylist(ctx, d.Store, rootdir, muxed); err != nil {
+		log.Println("Error writing playlist: " + err.Error())
+	}
+
+	log.Println("All found coubs downloaded")
+	return nil
+}
+
+// processCoub runs coub through the full download/mux/store/nfo pipeline and
+// reports whether it ended up with a muxed `_looped.mp4` on disk — false on any
+// pipeline failure, and also false when ffmpeg isn't on PATH and muxing was
+// skipped. Callers use this to decide whether coub belongs in WritePlaylist,
+// since a playlist entry pointing at a file that was never produced is a dead
+// link.
+func (d *Downloader) processCoub(ctx context.Context, rootdir string, coub Coub, state *StateDB) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	fail := func(stage string, err error) {
+		log.Println("Error processing coub: "+coub.Title, err)
+		d.emit(ProgressEvent{CoubID: coub.ID, Title: coub.Title, Stage: stage, Err: err})
+		if state != nil {
+			if markErr := state.MarkFailed(coub); markErr != nil {
+				log.Println("Error recording failed state for: "+coub.Title, markErr)
+			}
+		}
+	}
+
+	log.Println("Processing Coub: " + coub.Title)
+	d.emit(ProgressEvent{CoubID: coub.ID, Title: coub.Title, Stage: "start"})
+
+	outdir, err := CreateCoubDir(rootdir, coub)
+	if err != nil {
+		fail("create-dir", err)
+		return false
+	}
+
+	if err := CreateCoubInfoFiles(ctx, d.Store, outdir, coub); err != nil {
+		fail("info-files", err)
+		return false
+	}
+
+	log.Println("Downloading Coub: " + coub.Title)
+	if err := d.downloadAssets(ctx, outdir, coub); err != nil {
+		fail("download", err)
+		return false
+	}
+	log.Println("Finished Downloading Coub: " + coub.Title)
+
+	muxed := true
+	if err := d.Muxer.Mux(outdir, coub); err != nil {
+		if errors.Is(err, ErrFFmpegUnavailable) {
+			log.Println("Skipping mux for: " + coub.Title + ": ffmpeg not found on PATH")
+			d.emit(ProgressEvent{CoubID: coub.ID, Title: coub.Title, Stage: "mux-skipped", Err: err})
+			muxed = false
+		} else {
+			fail("mux", err)
+			return false
+		}
+	}
+
+	if err := UploadCoubDir(ctx, d.Store, outdir, outdir); err != nil {
+		fail("upload", err)
+		return false
+	}
+
+	if err := WriteNFO(ctx, d.Store, outdir, coub); err != nil {
+		fail("nfo", err)
+		return false
+	}
+
+	if state != nil {
+		if err := state.MarkComplete(coub); err != nil {
+			log.Println("Error recording complete state for: "+coub.Title, err)
+		}
+	}
+
+	d.emit(ProgressEvent{CoubID: coub.ID, Title: coub.Title, Stage: "done"})
+	return muxed
+}
+
+// downloadAssets submits every asset job for a coub into the Downloader's shared
+// asset-worker pool and returns the first error encountered, if any. A failure
+// to Save the manifest afterward is also reported, unless a job error already
+// took priority.
+func (d *Downloader) downloadAssets(ctx context.Context, outdir string, coub Coub) (err error) {
+	manifest, loadErr := LoadManifest(outdir)
+	if loadErr != nil {
+		log.Println("Error loading manifest for: " + coub.Title + ": " + loadErr.Error())
+		manifest = newManifest(outdir)
+	}
+	defer func() {
+		if saveErr := manifest.Save(); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+
+	var jobs []AssetJob
+	jobs = append(jobs, FirstFrameVersionJobs(outdir, coub)...)
+	jobs = append(jobs, ImageVersionJobs(outdir, coub)...)
+	jobs = append(jobs, FileVersionJobs(outdir, coub)...)
+
+	results := make(chan error, len(jobs))
+	sent := 0
+submit:
+	for _, job := range jobs {
+		select {
+		case d.assetJobs <- assetTask{ctx: ctx, job: job, manifest: manifest, result: results}:
+			sent++
+		case <-ctx.Done():
+			break submit
+		}
+	}
+
+	var first error
+	for i := 0; i < sent; i++ {
+		if e := <-results; e != nil && first == nil {
+			first = e
+		}
+	}
+	if first == nil {
+		first = ctx.Err()
+	}
+	return first
+}
+
+// emit sends a progress event on Progress without blocking callers that never
+// read from it.
+func (d *Downloader) emit(evt ProgressEvent) {
+	if d.Progress == nil {
+		return
+	}
+	select {
+	case d.Progress <- evt:
+	default:
+	}
+}